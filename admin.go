@@ -0,0 +1,143 @@
+package main
+
+import (
+  "crypto/subtle"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "time"
+)
+
+// AdminAPI exposes video management endpoints (listing, delete, rename,
+// upload) behind HTTP Basic Auth. It operates on the same VideoStore used
+// by the public-facing handlers so admin mutations are always consistent
+// with what uploaders and viewers see.
+type AdminAPI struct {
+  store   *VideoStore
+  config  *Config
+  uploads *UploadManager
+  index   *VideoIndex
+}
+
+// NewAdminAPI returns an AdminAPI backed by store and gated by the
+// credentials in config.
+func NewAdminAPI(store *VideoStore, config *Config, uploads *UploadManager, index *VideoIndex) *AdminAPI {
+  return &AdminAPI{store: store, config: config, uploads: uploads, index: index}
+}
+
+// Handler returns the mux to be mounted at "/admin/".
+func (api *AdminAPI) Handler() http.Handler {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/admin/", api.indexHandler)
+  mux.HandleFunc("/admin/delete", api.deleteHandler)
+  mux.HandleFunc("/admin/rename", api.renameHandler)
+  mux.HandleFunc("/admin/upload", api.uploadHandler)
+  return api.requireAuth(mux)
+}
+
+// requireAuth wraps a handler with HTTP Basic Auth, checked in constant
+// time against api.config. Failed attempts are slowed down to blunt
+// brute-force guessing.
+func (api *AdminAPI) requireAuth(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+    username, password, ok := request.BasicAuth()
+    if !ok || !api.validCredentials(username, password) {
+      time.Sleep(3 * time.Second)
+      writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, api.config.AppName))
+      http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+      return
+    }
+    next.ServeHTTP(writer, request)
+  })
+}
+
+// validCredentials reports whether username/password match any one of the
+// admin accounts in api.config.Users.
+func (api *AdminAPI) validCredentials(username string, password string) bool {
+  for _, user := range api.config.Users {
+    usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(user.Username)) == 1
+    passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1
+    if usernameMatch && passwordMatch {
+      return true
+    }
+  }
+  return false
+}
+
+// indexHandler lists every stored video along with management controls.
+func (api *AdminAPI) indexHandler(writer http.ResponseWriter, request *http.Request) {
+  data := struct {
+    AppName   string
+    VideoList []*Video
+  }{api.config.AppName, api.index.List()}
+  renderTemplate(writer, "admin", data)
+}
+
+// deleteHandler removes a video's directory entirely. id is required to
+// name a video the index already knows about, so a crafted or
+// traversal-laden id (e.g. "../../etc") is rejected before it ever
+// reaches VideoStore.Delete.
+func (api *AdminAPI) deleteHandler(writer http.ResponseWriter, request *http.Request) {
+  if request.Method != http.MethodPost {
+    http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  id := request.FormValue("id")
+  if _, ok := api.index.Get(id); !ok {
+    http.Error(writer, "video not found", http.StatusNotFound)
+    return
+  }
+  if err := api.store.Delete(id); err != nil {
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  api.index.Remove(id)
+  http.Redirect(writer, request, "/admin/", http.StatusSeeOther)
+}
+
+// renameHandler updates a video's stored title. Like deleteHandler, id
+// must already be present in the index.
+func (api *AdminAPI) renameHandler(writer http.ResponseWriter, request *http.Request) {
+  if request.Method != http.MethodPost {
+    http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  id := request.FormValue("id")
+  title := request.FormValue("title")
+  if _, ok := api.index.Get(id); !ok {
+    http.Error(writer, "video not found", http.StatusNotFound)
+    return
+  }
+  if err := api.store.Rename(id, title); err != nil {
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  if video, err := api.store.Load(id); err == nil {
+    api.index.Put(video)
+  }
+  http.Redirect(writer, request, "/admin/", http.StatusSeeOther)
+}
+
+// uploadHandler lets an admin push a video directly, bypassing the public
+// upload form. It drives the same chunked protocol as the public
+// endpoint, so large files and resumed transfers work here too.
+func (api *AdminAPI) uploadHandler(writer http.ResponseWriter, request *http.Request) {
+  if request.Method != http.MethodPost {
+    http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+
+  result, err := api.uploads.ReceiveChunk(request)
+  if err != nil {
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  if result.VideoID != "" {
+    http.Redirect(writer, request, "/watch/"+result.VideoID, http.StatusSeeOther)
+    return
+  }
+
+  writer.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(writer).Encode(result)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "os"
+  "strings"
+)
+
+// AdminUser is one set of HTTP Basic Auth credentials allowed to use the
+// admin API.
+type AdminUser struct {
+  Username string
+  Password string
+}
+
+// Config holds the admin accounts and server paths loaded from config.yml.
+// Parsing is intentionally minimal (flat "key: value" pairs, with "user:
+// name:password" repeated once per admin account) since the file never
+// needs more structure than that.
+type Config struct {
+  Users   []AdminUser
+  AppName string
+  DataDir string
+}
+
+// LoadConfig reads a config.yml file of "key: value" lines into a Config.
+// Every "user: name:password" line adds one admin account; at least one is
+// required.
+func LoadConfig(path string) (*Config, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  config := &Config{}
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    parts := strings.SplitN(line, ":", 2)
+    if len(parts) != 2 {
+      continue
+    }
+    key := strings.TrimSpace(parts[0])
+    value := strings.TrimSpace(parts[1])
+    switch key {
+    case "user":
+      user, err := parseAdminUser(value)
+      if err != nil {
+        return nil, fmt.Errorf("config.yml: %v", err)
+      }
+      config.Users = append(config.Users, user)
+    case "application_name":
+      config.AppName = value
+    case "data_dir":
+      config.DataDir = value
+    }
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+
+  if len(config.Users) == 0 {
+    return nil, fmt.Errorf(`config.yml: at least one "user: name:password" entry is required`)
+  }
+  if config.AppName == "" {
+    config.AppName = "GoLuTube"
+  }
+  if config.DataDir == "" {
+    config.DataDir = "videos"
+  }
+  return config, nil
+}
+
+// parseAdminUser splits a "user" line's value ("name:password") into an
+// AdminUser.
+func parseAdminUser(value string) (AdminUser, error) {
+  parts := strings.SplitN(value, ":", 2)
+  if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+    return AdminUser{}, fmt.Errorf("malformed user entry %q, want \"name:password\"", value)
+  }
+  return AdminUser{Username: parts[0], Password: parts[1]}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+  "fmt"
+  "log"
+  "net"
+  "net/http"
+  "os"
+  "strings"
+  "time"
+)
+
+// Logger wraps an http.Handler, recording method, path, remote address,
+// status code, bytes written, and elapsed time for every request it
+// serves.
+type Logger struct {
+  next http.Handler
+}
+
+// NewLogger wraps next with request logging.
+func NewLogger(next http.Handler) *Logger {
+  return &Logger{next: next}
+}
+
+func (logger *Logger) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  start := time.Now()
+  wrapped := &responseWriter{ResponseWriter: writer, status: http.StatusOK}
+
+  logger.next.ServeHTTP(wrapped, request)
+
+  log.Printf("%s %s %s %d %dB %s",
+    colorMethod(request.Method),
+    request.URL.Path,
+    remoteAddr(request),
+    wrapped.status,
+    wrapped.bytes,
+    time.Since(start),
+  )
+}
+
+// responseWriter captures the status code and byte count of a response so
+// Logger can report them after the handler returns.
+type responseWriter struct {
+  http.ResponseWriter
+  status int
+  bytes  int
+}
+
+func (writer *responseWriter) WriteHeader(status int) {
+  writer.status = status
+  writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *responseWriter) Write(data []byte) (int, error) {
+  n, err := writer.ResponseWriter.Write(data)
+  writer.bytes += n
+  return n, err
+}
+
+// remoteAddr prefers X-Real-IP / X-Forwarded-For, set by a reverse proxy
+// in front of the server, over request.RemoteAddr.
+func remoteAddr(request *http.Request) string {
+  if ip := request.Header.Get("X-Real-IP"); ip != "" {
+    return ip
+  }
+  if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+    return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+  }
+  if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+    return host
+  }
+  return request.RemoteAddr
+}
+
+// methodColors assigns an ANSI color to each common HTTP method.
+var methodColors = map[string]string{
+  http.MethodGet:    "34", // blue
+  http.MethodPost:   "32", // green
+  http.MethodPut:    "33", // yellow
+  http.MethodDelete: "31", // red
+}
+
+// colorMethod wraps method in its ANSI color code when stdout is a TTY,
+// and leaves it plain otherwise (e.g. when logs are piped to a file).
+func colorMethod(method string) string {
+  color, ok := methodColors[method]
+  if !ok || !isTerminal(os.Stdout) {
+    return method
+  }
+  return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, method)
+}
+
+func isTerminal(file *os.File) bool {
+  info, err := file.Stat()
+  if err != nil {
+    return false
+  }
+  return (info.Mode() & os.ModeCharDevice) != 0
+}
@@ -1,59 +1,37 @@
 package main
 
 import (
-  "os"
-  "io"
-  "io/ioutil"
+  "context"
+  "encoding/json"
   "net/http"
   "net/url"
   "html/template"
   "log"
+  "os"
+  "os/signal"
+  "syscall"
+  "time"
 )
 
 // Basic structure for storing the important information about a video.
 type Video struct {
-  Id string
-  Title string
+  Id         string
+  Title      string
+  Status     VideoStatus
+  UploadedAt time.Time
+  Duration   time.Duration
+  Size       int64
+  Extension  string
 }
 
-// Given a video ID, creates a Video object by fetching the relevant
-// information from the file system.
-func loadVideo(id string) (*Video, error) {
-  filename := "videos/" + id + "/videodata.txt"
-  videoData, err := ioutil.ReadFile(filename)
-  if err != nil {
-    return nil, err
-  }
-  title := string(videoData)
-  return &Video{Id: id, Title: title}, nil
-}
+// The VideoStore backing every handler below. Set once in main.
+var store *VideoStore
 
-// Given a video information structure and a video file, saves the video
-// information and the file to disc.
-func saveVideo(video *Video, videoFile io.Reader) error {
-  // Create the video file on the server and copies the network file to it.
-  videoDirectory := "./videos/" + video.Id
-  serverVideoFile, err := os.Create(videoDirectory + "/video.mp4")
-  if err != nil {
-    return err
-  }
-  defer serverVideoFile.Close()
+// The UploadManager driving the chunked upload protocol. Set once in main.
+var uploads *UploadManager
 
-  _, err1 := io.Copy(serverVideoFile, videoFile)
-  if err1 != nil {
-    return err
-  }
-
-  // Store the title of the video into the 'videodata.txt' file.
-  videoDataFile, err2 := os.Create(videoDirectory + "/videodata.txt")
-  if err2 != nil {
-    return err
-  }
-  defer videoDataFile.Close()
-
-  _, err3 := videoDataFile.WriteString(video.Title)
-  return err3
-}
+// The in-memory VideoIndex backing listing and search. Set once in main.
+var videoIndex *VideoIndex
 
 // Takes an HTML template and a collection of data used to populate it, and
 // renders the template, broadcasting it to a given HTTP response writer.
@@ -66,28 +44,26 @@ func renderTemplate(writer http.ResponseWriter, templateFile string, data interf
 }
 
 // HTTP handler used for watching videos. Loads the video from its ID and
-// uses it to create the HTTP response page.
+// uses it to create the HTTP response page. When the video's HLS
+// transcode has finished, the page is pointed at its manifest so the
+// player can stream adaptively instead of falling back to the raw MP4.
 func watchHandler(writer http.ResponseWriter, request *http.Request) {
   id := request.URL.Path[len("/watch/"):]
-  video, err := loadVideo(id)
+  video, err := store.Load(id)
   if err != nil {
     http.Redirect(writer, request, "/?error=notfound&id=" + id, http.StatusSeeOther)
+    return
   }
-  renderTemplate(writer, "watch", video)
-}
 
-// Gets the list of all videos stored on the file system.
-func getAvailableVideos() ([]*Video, error) {
-  videoDirectories, err := ioutil.ReadDir("videos")
-  if err != nil {
-    return nil, err
-  }
-  availableVideos := make([]*Video, 0)
-  for _, f := range videoDirectories {
-    video, _ := loadVideo(f.Name())
-    availableVideos = append(availableVideos, video)
+  data := struct {
+    *Video
+    HLSManifest string
+  }{video, ""}
+  if _, err := os.Stat(store.videoDir(id) + "/hls/index.m3u8"); err == nil {
+    data.HLSManifest = "/hls/" + id + "/index.m3u8"
   }
-  return availableVideos, nil
+
+  renderTemplate(writer, "watch", data)
 }
 
 // Decodes error codes from URL queries into messages displayed on the page.
@@ -105,10 +81,7 @@ func getErrorMessage(query url.Values) string {
 // HTTP handler used for rendering the home page. Displays a list of all the
 // videos living on the file system.
 func homeHandler(writer http.ResponseWriter, request *http.Request) error {
-  videoList, err := getAvailableVideos()
-  if err != nil {
-    return err
-  }
+  videoList := videoIndex.List()
   errorMessage := getErrorMessage(request.URL.Query())
 
   data := struct {
@@ -122,40 +95,23 @@ func homeHandler(writer http.ResponseWriter, request *http.Request) error {
   return nil
 }
 
-// Handles POST requests for the uploading of videos. Uploads the video to
-// the server along with its title.
+// Handles POST requests for the uploading of videos. Each request carries
+// one chunk of the file; the response reports upload progress as JSON
+// until the final chunk lands, at which point the user is redirected to
+// watch the now-complete video.
 func uploadHandler(writer http.ResponseWriter, request *http.Request) error {
-  // Parse the request and extract the video and title from it.
-  err := request.ParseMultipartForm(64 << 20)
+  result, err := uploads.ReceiveChunk(request)
   if err != nil {
     return err
   }
 
-  videoFile, _, err1 := request.FormFile("video-file")
-  if err1 != nil {
-    return err1
-  }
-  defer videoFile.Close()
-  title := request.FormValue("title")
-
-  // The TempDir function creates a unique subdirectory of a given directory.
-  // Use this to generate a unique ID for the new video.
-  videoDir, err2 := ioutil.TempDir("videos/", "")
-  if err2 != nil {
-    return err2
-  }
-  id := videoDir[len("./video"):]
-
-  // Save the video to the server and redirect the user to the new page where
-  // they can watch it.
-  err3 := saveVideo(&Video{Id: id, Title: title}, videoFile)
-  if err3 != nil {
-    http.Redirect(writer, request, "/?error=fu", http.StatusSeeOther)
+  if result.VideoID != "" {
+    http.Redirect(writer, request, "/watch/" + result.VideoID, http.StatusSeeOther)
     return nil
   }
 
-  http.Redirect(writer, request, "/watch/" + id, http.StatusSeeOther)
-  return nil
+  writer.Header().Set("Content-Type", "application/json")
+  return json.NewEncoder(writer).Encode(result)
 }
 
 // A function that deals with HTTP requests and returns an error. Should
@@ -184,9 +140,51 @@ func (fn appHandler) homePageHandler(writer http.ResponseWriter, request *http.R
 
 // Sets up the handlers and serves on to port 8080.
 func main() {
-  http.HandleFunc("/watch/", watchHandler)
-  http.Handle("/videos/", http.FileServer(http.Dir(".")))
-  http.HandleFunc("/upload/", appHandler(uploadHandler).homePageHandler)
-  http.HandleFunc("/", appHandler(homeHandler).internalServerErrorHandler)
-  log.Fatal(http.ListenAndServe(":8080", nil))
+  config, err := LoadConfig("config.yml")
+  if err != nil {
+    log.Fatal(err)
+  }
+  store = NewVideoStore(config.DataDir)
+
+  videoIndex = NewVideoIndex(store)
+  if err := videoIndex.Scan(); err != nil {
+    log.Fatal(err)
+  }
+  videoIndex.Watch(30 * time.Second)
+
+  transcoder := NewTranscoder(store, videoIndex, 2)
+  uploads = NewUploadManager(store, transcoder)
+  uploads.StartJanitor(6 * time.Hour)
+
+  admin := NewAdminAPI(store, config, uploads, videoIndex)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("/watch/", watchHandler)
+  mux.Handle("/videos/", newVideoServer(store.DataDir))
+  mux.HandleFunc("/hls/", hlsHandler)
+  mux.HandleFunc("/search", searchHandler)
+  mux.HandleFunc("/upload/", appHandler(uploadHandler).homePageHandler)
+  mux.Handle("/admin/", admin.Handler())
+  mux.HandleFunc("/", appHandler(homeHandler).internalServerErrorHandler)
+
+  server := &http.Server{
+    Addr:    ":8080",
+    Handler: NewLogger(mux),
+  }
+
+  go func() {
+    if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+      log.Fatal(err)
+    }
+  }()
+
+  shutdownSignal := make(chan os.Signal, 1)
+  signal.Notify(shutdownSignal, syscall.SIGINT, syscall.SIGTERM)
+  <-shutdownSignal
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+  if err := server.Shutdown(ctx); err != nil {
+    log.Printf("graceful shutdown: %v", err)
+  }
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// hlsRendition is one quality level produced for every upload.
+type hlsRendition struct {
+  Name        string
+  Width       int
+  Height      int
+  BitrateKbps int
+}
+
+// hlsRenditions are the fixed set of quality levels every upload is
+// transcoded into.
+var hlsRenditions = []hlsRendition{
+  {"360p", 640, 360, 800},
+  {"720p", 1280, 720, 2800},
+  {"1080p", 1920, 1080, 5000},
+}
+
+// Transcoder segments uploaded videos into HLS renditions using ffmpeg,
+// bounded by a fixed-size worker pool so a burst of uploads can't fork an
+// unbounded number of ffmpeg processes.
+type Transcoder struct {
+  store *VideoStore
+  index *VideoIndex
+  jobs  chan string
+}
+
+// NewTranscoder returns a Transcoder with workers goroutines draining its
+// job queue.
+func NewTranscoder(store *VideoStore, index *VideoIndex, workers int) *Transcoder {
+  transcoder := &Transcoder{store: store, index: index, jobs: make(chan string, 64)}
+  for i := 0; i < workers; i++ {
+    go transcoder.worker()
+  }
+  return transcoder
+}
+
+// setStatus persists status for id and refreshes the index entry so
+// readers see the new status immediately, rather than waiting for the
+// index's next periodic rescan.
+func (transcoder *Transcoder) setStatus(id string, status VideoStatus) error {
+  if err := transcoder.store.SetStatus(id, status); err != nil {
+    return err
+  }
+  if video, err := transcoder.store.Load(id); err == nil {
+    transcoder.index.Put(video)
+  }
+  return nil
+}
+
+// Enqueue schedules id for transcoding. Safe to call from any goroutine;
+// blocks only once the queue is full.
+func (transcoder *Transcoder) Enqueue(id string) {
+  transcoder.jobs <- id
+}
+
+func (transcoder *Transcoder) worker() {
+  for id := range transcoder.jobs {
+    transcoder.transcode(id)
+  }
+}
+
+// transcode produces every rendition plus a master manifest for id,
+// recording progress and outcome on the Video's Status.
+func (transcoder *Transcoder) transcode(id string) {
+  if err := transcoder.setStatus(id, StatusTranscoding); err != nil {
+    log.Printf("transcode %s: %v", id, err)
+    return
+  }
+
+  videoDir := transcoder.store.videoDir(id)
+  hlsDir := videoDir + "/hls"
+  if err := os.MkdirAll(hlsDir, 0755); err != nil {
+    transcoder.fail(id, err)
+    return
+  }
+
+  variantManifests := make([]string, 0, len(hlsRenditions))
+  for _, rendition := range hlsRenditions {
+    manifest := rendition.Name + ".m3u8"
+    cmd := exec.Command("ffmpeg",
+      "-y",
+      "-i", videoDir+"/video.mp4",
+      "-vf", fmt.Sprintf("scale=%d:%d", rendition.Width, rendition.Height),
+      "-b:v", fmt.Sprintf("%dk", rendition.BitrateKbps),
+      "-c:a", "aac",
+      "-hls_time", "6",
+      "-hls_playlist_type", "vod",
+      "-hls_segment_filename", hlsDir+"/"+rendition.Name+"_%03d.ts",
+      hlsDir+"/"+manifest,
+    )
+    if output, err := cmd.CombinedOutput(); err != nil {
+      transcoder.fail(id, fmt.Errorf("%s: %v: %s", rendition.Name, err, output))
+      return
+    }
+    variantManifests = append(variantManifests, manifest)
+  }
+
+  if err := writeMasterManifest(hlsDir+"/index.m3u8", hlsRenditions, variantManifests); err != nil {
+    transcoder.fail(id, err)
+    return
+  }
+
+  if err := transcoder.setStatus(id, StatusReady); err != nil {
+    log.Printf("transcode %s: %v", id, err)
+  }
+}
+
+func (transcoder *Transcoder) fail(id string, cause error) {
+  log.Printf("transcode %s failed: %v", id, cause)
+  if err := transcoder.setStatus(id, StatusFailed); err != nil {
+    log.Printf("transcode %s: failed to record failure: %v", id, err)
+  }
+}
+
+// writeMasterManifest writes an HLS master playlist selecting between
+// variants by bandwidth and resolution.
+func writeMasterManifest(path string, renditions []hlsRendition, variants []string) error {
+  var builder strings.Builder
+  builder.WriteString("#EXTM3U\n")
+  for i, rendition := range renditions {
+    fmt.Fprintf(&builder, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n",
+      rendition.BitrateKbps*1000, rendition.Width, rendition.Height)
+    builder.WriteString(variants[i] + "\n")
+  }
+  return ioutil.WriteFile(path, []byte(builder.String()), 0644)
+}
+
+// probeDuration returns the duration of the media file at path using
+// ffprobe. Used by VideoStore.Finalize to stamp Video.Duration once an
+// upload finishes.
+func probeDuration(path string) (time.Duration, error) {
+  output, err := exec.Command("ffprobe",
+    "-v", "error",
+    "-show_entries", "format=duration",
+    "-of", "default=noprint_wrappers=1:nokey=1",
+    path,
+  ).Output()
+  if err != nil {
+    return 0, err
+  }
+
+  seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+  if err != nil {
+    return 0, err
+  }
+  return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// hlsHandler serves a video's HLS manifest and segments from
+// <DataDir>/<id>/hls/<file>, with the content types HLS.js players expect,
+// and a clean 404 for anything that doesn't exist yet (including a
+// request that tries to traverse out of that directory).
+func hlsHandler(writer http.ResponseWriter, request *http.Request) {
+  id, file := splitFirstSegment(request.URL.Path[len("/hls/"):])
+  if id == "" || file == "" {
+    notFoundHandler(writer, request)
+    return
+  }
+
+  path, err := resolveInRoot(store.videoDir(id)+"/hls", file)
+  if err != nil {
+    notFoundHandler(writer, request)
+    return
+  }
+  if info, err := os.Stat(path); err != nil || info.IsDir() {
+    notFoundHandler(writer, request)
+    return
+  }
+
+  switch filepath.Ext(file) {
+  case ".m3u8":
+    writer.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+  case ".ts":
+    writer.Header().Set("Content-Type", "video/mp2t")
+  }
+  http.ServeFile(writer, request, path)
+}
+
+// splitFirstSegment splits "id/rest/of/path" into ("id", "rest/of/path").
+func splitFirstSegment(path string) (string, string) {
+  parts := strings.SplitN(path, "/", 2)
+  if len(parts) != 2 {
+    return "", ""
+  }
+  return parts[0], parts[1]
+}
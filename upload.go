@@ -0,0 +1,251 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "strconv"
+  "sync"
+  "time"
+)
+
+// UploadManager tracks in-progress chunked uploads so videos larger than
+// server RAM can be sent across multiple requests and resumed after a
+// dropped connection.
+type UploadManager struct {
+  store      *VideoStore
+  transcoder *Transcoder
+
+  mutex   sync.Mutex
+  uploads map[string]*pendingUpload
+}
+
+// pendingUpload is the state kept for an upload that hasn't received its
+// final chunk yet. Its id doubles as the eventual Video.Id. Each chunk is
+// written to its own file under chunksDir, keyed by index, so a retried
+// or duplicate chunk simply overwrites its own file instead of corrupting
+// anything, and chunks can arrive out of order or concurrently.
+type pendingUpload struct {
+  id          string
+  videoDir    string
+  chunksDir   string
+  title       string
+  totalChunks int
+  received    map[int]bool
+  assembling  bool
+  lastActive  time.Time
+}
+
+// NewUploadManager returns an UploadManager that lands finished uploads in
+// store and hands them off to transcoder for HLS segmenting.
+func NewUploadManager(store *VideoStore, transcoder *Transcoder) *UploadManager {
+  return &UploadManager{
+    store:      store,
+    transcoder: transcoder,
+    uploads:    make(map[string]*pendingUpload),
+  }
+}
+
+// ChunkResult is the JSON response sent back after every chunk so the
+// client can drive a progress bar and retry a specific chunk. VideoID is
+// only set once every chunk has been received and assembled.
+type ChunkResult struct {
+  UploadID string `json:"uploadID"`
+  Received int    `json:"received"`
+  Total    int    `json:"total"`
+  VideoID  string `json:"videoID,omitempty"`
+}
+
+// ReceiveChunk writes one chunk of a chunked upload to its own file,
+// creating a new upload (and its directories) on the first chunk
+// (request.FormValue "uploadID" empty). Once every chunk in [0,
+// totalChunks) has landed — regardless of the order they arrived in, and
+// tolerating retries of a chunk already received — it assembles them into
+// video.mp4 and reports the finished VideoID.
+func (manager *UploadManager) ReceiveChunk(request *http.Request) (*ChunkResult, error) {
+  if err := request.ParseMultipartForm(32 << 20); err != nil {
+    return nil, err
+  }
+
+  chunkIndex, err := strconv.Atoi(request.FormValue("chunkIndex"))
+  if err != nil {
+    return nil, fmt.Errorf("invalid chunkIndex: %v", err)
+  }
+  totalChunks, err := strconv.Atoi(request.FormValue("totalChunks"))
+  if err != nil {
+    return nil, fmt.Errorf("invalid totalChunks: %v", err)
+  }
+
+  chunk, _, err := request.FormFile("chunk")
+  if err != nil {
+    return nil, err
+  }
+  defer chunk.Close()
+
+  upload, err := manager.pendingUpload(request.FormValue("uploadID"), totalChunks, request.FormValue("title"))
+  if err != nil {
+    return nil, err
+  }
+
+  if chunkIndex < 0 || chunkIndex >= upload.totalChunks {
+    return nil, fmt.Errorf("chunkIndex %d out of range [0,%d)", chunkIndex, upload.totalChunks)
+  }
+
+  if err := writeChunkFile(chunkPath(upload.chunksDir, chunkIndex), chunk); err != nil {
+    return nil, err
+  }
+
+  manager.mutex.Lock()
+  upload.received[chunkIndex] = true
+  upload.lastActive = time.Now()
+  received := len(upload.received)
+  shouldAssemble := received == upload.totalChunks && !upload.assembling
+  if shouldAssemble {
+    upload.assembling = true
+  }
+  manager.mutex.Unlock()
+
+  result := &ChunkResult{UploadID: upload.id, Received: received, Total: upload.totalChunks}
+
+  // Only the goroutine that flipped assembling false->true above actually
+  // assembles. A concurrent retry of the terminal chunk (the case this
+  // protocol exists to support) sees shouldAssemble false and just reports
+  // progress. assembling is cleared on failure so a later retry can try
+  // again; on success the upload is removed from manager.uploads, so there
+  // is nothing left for a late retry to race with.
+  if shouldAssemble {
+    video := &Video{Id: upload.id, Title: upload.title}
+    if err := manager.assemble(upload, video); err != nil {
+      manager.mutex.Lock()
+      upload.assembling = false
+      manager.mutex.Unlock()
+      return nil, err
+    }
+    videoIndex.Put(video)
+
+    manager.mutex.Lock()
+    delete(manager.uploads, upload.id)
+    manager.mutex.Unlock()
+
+    result.VideoID = upload.id
+    manager.transcoder.Enqueue(upload.id)
+  }
+
+  return result, nil
+}
+
+// pendingUpload returns the upload state for uploadID, creating a new one
+// (and its video/chunk directories) when uploadID is empty or unknown.
+func (manager *UploadManager) pendingUpload(uploadID string, totalChunks int, title string) (*pendingUpload, error) {
+  manager.mutex.Lock()
+  defer manager.mutex.Unlock()
+
+  if uploadID != "" {
+    if upload, ok := manager.uploads[uploadID]; ok {
+      return upload, nil
+    }
+  }
+
+  videoDir, err := ioutil.TempDir(manager.store.DataDir, "")
+  if err != nil {
+    return nil, err
+  }
+  id := videoDir[len(manager.store.DataDir)+1:]
+
+  chunksDir := videoDir + "/chunks"
+  if err := os.MkdirAll(chunksDir, 0755); err != nil {
+    return nil, err
+  }
+
+  upload := &pendingUpload{
+    id:          id,
+    videoDir:    videoDir,
+    chunksDir:   chunksDir,
+    title:       title,
+    totalChunks: totalChunks,
+    received:    make(map[int]bool),
+    lastActive:  time.Now(),
+  }
+  manager.uploads[id] = upload
+  return upload, nil
+}
+
+// chunkPath returns the path a chunk at index is written to. Every chunk
+// gets its own file, so writing it is naturally idempotent: a retried or
+// duplicate delivery just overwrites the same file rather than appending
+// to a shared one.
+func chunkPath(chunksDir string, index int) string {
+  return fmt.Sprintf("%s/%06d", chunksDir, index)
+}
+
+// writeChunkFile (re)creates path from chunk, discarding whatever was
+// there before.
+func writeChunkFile(path string, chunk io.Reader) error {
+  file, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  _, err = io.Copy(file, chunk)
+  return err
+}
+
+// assemble concatenates every chunk file, in index order, into video.mp4,
+// finalizes the video's metadata, and removes the now-redundant chunk
+// files.
+func (manager *UploadManager) assemble(upload *pendingUpload, video *Video) error {
+  videoFile, err := os.Create(upload.videoDir + "/video.mp4")
+  if err != nil {
+    return err
+  }
+  defer videoFile.Close()
+
+  for i := 0; i < upload.totalChunks; i++ {
+    if err := appendChunk(videoFile, chunkPath(upload.chunksDir, i)); err != nil {
+      return fmt.Errorf("assembling chunk %d: %v", i, err)
+    }
+  }
+
+  if err := manager.store.Finalize(video); err != nil {
+    return err
+  }
+  return os.RemoveAll(upload.chunksDir)
+}
+
+func appendChunk(destination *os.File, chunkPath string) error {
+  chunkFile, err := os.Open(chunkPath)
+  if err != nil {
+    return err
+  }
+  defer chunkFile.Close()
+
+  _, err = io.Copy(destination, chunkFile)
+  return err
+}
+
+// StartJanitor runs in the background for the lifetime of the process,
+// removing upload directories that haven't received a chunk in longer
+// than maxAge. Call once from main.
+func (manager *UploadManager) StartJanitor(maxAge time.Duration) {
+  ticker := time.NewTicker(maxAge / 2)
+  go func() {
+    for range ticker.C {
+      manager.collectStale(maxAge)
+    }
+  }()
+}
+
+func (manager *UploadManager) collectStale(maxAge time.Duration) {
+  manager.mutex.Lock()
+  defer manager.mutex.Unlock()
+
+  for id, upload := range manager.uploads {
+    if time.Since(upload.lastActive) > maxAge {
+      os.RemoveAll(upload.videoDir)
+      delete(manager.uploads, id)
+    }
+  }
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+  "io/ioutil"
+  "log"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// VideoIndex is an in-memory, concurrency-safe view of every video on
+// disk, keyed by ID. Handlers read through the index instead of walking
+// the file system on every request; Scan (run at startup and on an
+// interval) is the only thing that touches disk.
+type VideoIndex struct {
+  store *VideoStore
+
+  mutex  sync.RWMutex
+  videos map[string]*Video
+}
+
+// NewVideoIndex returns an empty VideoIndex backed by store. Call Scan to
+// populate it before serving traffic.
+func NewVideoIndex(store *VideoStore) *VideoIndex {
+  return &VideoIndex{store: store, videos: make(map[string]*Video)}
+}
+
+// Scan walks store.DataDir and rebuilds the index from scratch, skipping
+// any video whose sidecar fails to load instead of recording it as a nil
+// entry.
+func (index *VideoIndex) Scan() error {
+  entries, err := ioutil.ReadDir(index.store.DataDir)
+  if err != nil {
+    return err
+  }
+
+  videos := make(map[string]*Video, len(entries))
+  for _, entry := range entries {
+    video, err := index.store.Load(entry.Name())
+    if err != nil {
+      continue
+    }
+    videos[video.Id] = video
+  }
+
+  index.mutex.Lock()
+  index.videos = videos
+  index.mutex.Unlock()
+  return nil
+}
+
+// Watch rescans the index on the given interval for the lifetime of the
+// process. Call once from main.
+func (index *VideoIndex) Watch(interval time.Duration) {
+  ticker := time.NewTicker(interval)
+  go func() {
+    for range ticker.C {
+      if err := index.Scan(); err != nil {
+        log.Printf("video index: rescan failed: %v", err)
+      }
+    }
+  }()
+}
+
+// List returns every indexed video, copied from the in-memory map rather
+// than re-read from the file system per request. The order of the
+// returned videos is not defined.
+func (index *VideoIndex) List() []*Video {
+  index.mutex.RLock()
+  defer index.mutex.RUnlock()
+
+  videos := make([]*Video, 0, len(index.videos))
+  for _, video := range index.videos {
+    videos = append(videos, video)
+  }
+  return videos
+}
+
+// Get looks up a single video by ID in O(1), reporting whether it was
+// found.
+func (index *VideoIndex) Get(id string) (*Video, bool) {
+  index.mutex.RLock()
+  defer index.mutex.RUnlock()
+  video, ok := index.videos[id]
+  return video, ok
+}
+
+// Search returns every video whose title contains any whitespace-
+// separated token of q, case-insensitively.
+func (index *VideoIndex) Search(q string) []*Video {
+  tokens := strings.Fields(strings.ToLower(q))
+  if len(tokens) == 0 {
+    return nil
+  }
+
+  index.mutex.RLock()
+  defer index.mutex.RUnlock()
+
+  results := make([]*Video, 0)
+  for _, video := range index.videos {
+    title := strings.ToLower(video.Title)
+    for _, token := range tokens {
+      if strings.Contains(title, token) {
+        results = append(results, video)
+        break
+      }
+    }
+  }
+  return results
+}
+
+// Put inserts or replaces a single video in the index without a full
+// rescan. Used by upload/admin code paths that already know what changed.
+func (index *VideoIndex) Put(video *Video) {
+  index.mutex.Lock()
+  index.videos[video.Id] = video
+  index.mutex.Unlock()
+}
+
+// Remove deletes a video from the index.
+func (index *VideoIndex) Remove(id string) {
+  index.mutex.Lock()
+  delete(index.videos, id)
+  index.mutex.Unlock()
+}
+
+// SearchPageData is passed to search.html.
+type SearchPageData struct {
+  Query   string
+  Results []*Video
+}
+
+// searchHandler renders the videos whose title matches the "q" query
+// parameter.
+func searchHandler(writer http.ResponseWriter, request *http.Request) {
+  query := request.URL.Query().Get("q")
+  data := SearchPageData{
+    Query:   query,
+    Results: videoIndex.Search(query),
+  }
+  renderTemplate(writer, "search", data)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// videoServer serves files from within a video store's data directory,
+// guarding against path traversal and symlink escapes, and using
+// http.ServeContent so Range requests (seeking), If-Modified-Since, and
+// Content-Type sniffing all work for in-browser playback of large MP4s.
+type videoServer struct {
+  root string
+}
+
+// newVideoServer returns a videoServer rooted at root (typically a
+// VideoStore's DataDir).
+func newVideoServer(root string) *videoServer {
+  return &videoServer{root: root}
+}
+
+func (server *videoServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  requestPath := strings.TrimPrefix(request.URL.Path, "/videos/")
+
+  path, err := resolveInRoot(server.root, requestPath)
+  if err != nil {
+    notFoundHandler(writer, request)
+    return
+  }
+
+  file, err := os.Open(path)
+  if err != nil {
+    notFoundHandler(writer, request)
+    return
+  }
+  defer file.Close()
+
+  info, err := file.Stat()
+  if err != nil || info.IsDir() {
+    notFoundHandler(writer, request)
+    return
+  }
+
+  http.ServeContent(writer, request, info.Name(), info.ModTime(), file)
+}
+
+// resolveInRoot turns a request path into an absolute file path
+// guaranteed to live inside root, rejecting ".." traversal and symlinks
+// that escape it. Shared by every handler that serves files out of a
+// directory keyed by a client-supplied path (the /videos/ file server,
+// the /hls/ manifest and segment server).
+func resolveInRoot(root string, requestPath string) (string, error) {
+  root, err := filepath.Abs(root)
+  if err != nil {
+    return "", err
+  }
+  root, err = filepath.EvalSymlinks(root)
+  if err != nil {
+    return "", err
+  }
+
+  joined := filepath.Join(root, requestPath)
+  resolved, err := filepath.EvalSymlinks(joined)
+  if err != nil {
+    return "", err
+  }
+
+  if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+    return "", fmt.Errorf("path %q escapes %q", requestPath, root)
+  }
+  return resolved, nil
+}
+
+// notFoundHandler renders the 404 page rather than returning the default
+// plain-text "404 page not found" response.
+func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
+  writer.WriteHeader(http.StatusNotFound)
+  renderTemplate(writer, "404", nil)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "time"
+)
+
+// VideoStatus tracks where a video is in the upload/transcode pipeline.
+type VideoStatus string
+
+const (
+  StatusUploaded    VideoStatus = "uploaded"
+  StatusTranscoding VideoStatus = "transcoding"
+  StatusReady       VideoStatus = "ready"
+  StatusFailed      VideoStatus = "failed"
+)
+
+// VideoStore manages the on-disk layout of uploaded videos, rooted at a
+// configurable data directory (<DataDir>/<id>/video.mp4,
+// <DataDir>/<id>/videodata.json). Centralizing the layout here lets admin
+// operations (delete, rename) and the transcoder mutate the same files
+// that uploads and playback rely on without duplicating path logic.
+type VideoStore struct {
+  DataDir string
+}
+
+// NewVideoStore returns a VideoStore rooted at dataDir.
+func NewVideoStore(dataDir string) *VideoStore {
+  return &VideoStore{DataDir: dataDir}
+}
+
+func (store *VideoStore) videoDir(id string) string {
+  return store.DataDir + "/" + id
+}
+
+// validVideoID reports whether id is safe to use as a single path segment
+// under DataDir: non-empty, no path separators, and no ".." traversal.
+// Every VideoStore method that takes a caller-supplied id (as opposed to
+// one it generated itself, e.g. via ioutil.TempDir) must check this
+// before touching the file system.
+func validVideoID(id string) bool {
+  return id != "" && id != "." && id != ".." && id == filepath.Base(id)
+}
+
+// Load creates a Video object by fetching the relevant information from the
+// file system.
+func (store *VideoStore) Load(id string) (*Video, error) {
+  if !validVideoID(id) {
+    return nil, fmt.Errorf("invalid video id %q", id)
+  }
+  filename := store.videoDir(id) + "/videodata.json"
+  data, err := ioutil.ReadFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  video := &Video{}
+  if err := json.Unmarshal(data, video); err != nil {
+    return nil, err
+  }
+  video.Id = id
+  return video, nil
+}
+
+// Save writes the video file and its metadata to disc, marking the video
+// as uploaded (transcoding, if any, happens afterwards).
+func (store *VideoStore) Save(video *Video, videoFile io.Reader) error {
+  videoDirectory := store.videoDir(video.Id)
+  serverVideoFile, err := os.Create(videoDirectory + "/video.mp4")
+  if err != nil {
+    return err
+  }
+  defer serverVideoFile.Close()
+
+  _, err1 := io.Copy(serverVideoFile, videoFile)
+  if err1 != nil {
+    return err1
+  }
+
+  video.Status = StatusUploaded
+  return store.writeMetadata(videoDirectory, video)
+}
+
+func (store *VideoStore) writeMetadata(videoDirectory string, video *Video) error {
+  data, err := json.MarshalIndent(video, "", "  ")
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile(videoDirectory+"/videodata.json", data, 0644)
+}
+
+// Delete removes a video's directory and everything inside it.
+func (store *VideoStore) Delete(id string) error {
+  if !validVideoID(id) {
+    return fmt.Errorf("invalid video id %q", id)
+  }
+  return os.RemoveAll(store.videoDir(id))
+}
+
+// Rename overwrites the title stored for a video in its videodata.json.
+func (store *VideoStore) Rename(id string, title string) error {
+  video, err := store.Load(id)
+  if err != nil {
+    return err
+  }
+  video.Title = title
+  return store.writeMetadata(store.videoDir(id), video)
+}
+
+// Finalize stamps video with the metadata only known once its bytes are
+// completely written (size, extension, upload time, duration) and
+// persists it as StatusUploaded. The transcoder takes it from there.
+func (store *VideoStore) Finalize(video *Video) error {
+  videoDirectory := store.videoDir(video.Id)
+  videoPath := videoDirectory + "/video.mp4"
+
+  info, err := os.Stat(videoPath)
+  if err != nil {
+    return err
+  }
+  video.Size = info.Size()
+  video.Extension = ".mp4"
+  video.UploadedAt = time.Now()
+  video.Status = StatusUploaded
+
+  if duration, err := probeDuration(videoPath); err == nil {
+    video.Duration = duration
+  }
+
+  return store.writeMetadata(videoDirectory, video)
+}
+
+// SetStatus updates and persists a video's pipeline status.
+func (store *VideoStore) SetStatus(id string, status VideoStatus) error {
+  video, err := store.Load(id)
+  if err != nil {
+    return err
+  }
+  video.Status = status
+  return store.writeMetadata(store.videoDir(id), video)
+}